@@ -0,0 +1,210 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Collector is the interface community and built-in collectors implement to
+// be picked up by registerCollector, mirroring the node_exporter /
+// postgres_exporter collector registry pattern: a collector declares its
+// own name and produces metrics on demand, without exporter.go needing to
+// know it exists.
+type Collector interface {
+	// Name identifies the collector for the --collector.<name> /
+	// --no-collector.<name> flag pair and for the scrape_* meta-metrics.
+	Name() string
+	// Update sends the collector's metrics to ch. An error marks the
+	// collector's scrape as failed but does not abort the others.
+	Update(ch chan<- prometheus.Metric) error
+}
+
+// collectorOpts carries the per-scrape context a collectorFactory needs to
+// build a Collector instance.
+type collectorOpts struct {
+	ctx                   context.Context //nolint:containedctx
+	client                *mongo.Client
+	compatibleMode        bool
+	discoveringMode       bool
+	collStatsCollections  []string
+	indexStatsCollections []string
+	logger                *slog.Logger
+	topologyInfo          labelsGetter
+	nodeType              nodeType
+}
+
+type collectorFactory func(collectorOpts) Collector
+
+type registeredCollector struct {
+	name             string
+	defaultEnabled   bool
+	factory          collectorFactory
+	excludeNodeTypes []nodeType // collector is skipped when co.nodeType is one of these
+}
+
+var (
+	collectorsMu sync.Mutex
+	collectors   = map[string]registeredCollector{}
+)
+
+// registerCollector adds a Collector factory to the package-level registry.
+// It is meant to be called from a collector's init() function, one file per
+// collector, so new collectors (currentOp, oplog, profiler, ...) can be
+// shipped without touching exporter.go. excludeNodeTypes skips the collector
+// against matching deployments (e.g. replSetGetStatus doesn't run through a
+// mongos); pass nil to run against every node type.
+func registerCollector(name string, defaultEnabled bool, factory collectorFactory, excludeNodeTypes ...nodeType) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	if _, ok := collectors[name]; ok {
+		panic(fmt.Sprintf("collector %q registered twice", name))
+	}
+
+	collectors[name] = registeredCollector{
+		name:             name,
+		defaultEnabled:   defaultEnabled,
+		factory:          factory,
+		excludeNodeTypes: excludeNodeTypes,
+	}
+}
+
+// CollectorFlagDefaults returns the default enabled/disabled state for every
+// registered collector, keyed by name. The CLI layer uses this to generate
+// one --collector.<name> / --no-collector.<name> flag pair per collector.
+func CollectorFlagDefaults() map[string]bool {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	defaults := make(map[string]bool, len(collectors))
+	for name, c := range collectors {
+		defaults[name] = c.defaultEnabled
+	}
+
+	return defaults
+}
+
+func sortedCollectorNames() []string {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// registerEnabledCollectors instantiates every registered Collector that is
+// enabled in opts.Collectors and applicable to nodeType, wrapping each in a
+// prometheus.Collector adapter that also emits per-collector
+// scrape_duration_seconds / scrape_success meta-metrics.
+func registerEnabledCollectors(registry *prometheus.Registry, co collectorOpts, enabled map[string]bool) {
+	for _, name := range sortedCollectorNames() {
+		rc := collectors[name]
+
+		if on, ok := enabled[name]; ok {
+			if !on {
+				continue
+			}
+		} else if !rc.defaultEnabled {
+			continue
+		}
+
+		excluded := false
+		for _, nt := range rc.excludeNodeTypes {
+			if nt == co.nodeType {
+				excluded = true
+
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+
+		registry.MustRegister(newCollectorAdapter(name, rc.factory(co)))
+	}
+}
+
+// collectorAdapter adapts the simple Collector interface to
+// prometheus.Collector, adding scrape_duration_seconds and scrape_success
+// gauges for the wrapped collector, the same meta-metrics node_exporter
+// exposes per collector.
+//
+// A prometheus.Registry identifies a registered collector by the XOR of its
+// descriptors' IDs (fqName + ConstLabels, not the label values seen later at
+// Collect time), so the two meta-metric descriptors must bake the collector
+// name into ConstLabels rather than share package-level Descs across every
+// adapter - otherwise a second enabled collector collides with the first and
+// MustRegister panics with "duplicate metrics collector registration
+// attempted".
+type collectorAdapter struct {
+	name               string
+	collector          Collector
+	scrapeDurationDesc *prometheus.Desc
+	scrapeSuccessDesc  *prometheus.Desc
+}
+
+func newCollectorAdapter(name string, collector Collector) *collectorAdapter {
+	constLabels := prometheus.Labels{"collector": name}
+
+	return &collectorAdapter{
+		name:      name,
+		collector: collector,
+		scrapeDurationDesc: prometheus.NewDesc(
+			"mongodb_exporter_scrape_duration_seconds",
+			"mongodb_exporter: Duration of a collector's scrape.",
+			nil, constLabels,
+		),
+		scrapeSuccessDesc: prometheus.NewDesc(
+			"mongodb_exporter_scrape_success",
+			"mongodb_exporter: Whether a collector's scrape succeeded.",
+			nil, constLabels,
+		),
+	}
+}
+
+func (ca *collectorAdapter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- ca.scrapeDurationDesc
+	ch <- ca.scrapeSuccessDesc
+}
+
+func (ca *collectorAdapter) Collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	err := ca.collector.Update(ch)
+	duration := time.Since(start).Seconds()
+
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+
+	ch <- prometheus.MustNewConstMetric(ca.scrapeDurationDesc, prometheus.GaugeValue, duration)
+	ch <- prometheus.MustNewConstMetric(ca.scrapeSuccessDesc, prometheus.GaugeValue, success)
+}
@@ -0,0 +1,116 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// noopCollector is a Collector that does nothing, for exercising
+// registerEnabledCollectors' filtering logic without depending on a real
+// collector's behavior.
+type noopCollector struct{}
+
+func (noopCollector) Name() string                             { return "noop" }
+func (noopCollector) Update(ch chan<- prometheus.Metric) error { return nil }
+
+// registerCollectorForTest calls registerCollector and arranges for the
+// fixture to be removed from the package-level collectors map at the end of
+// the test, so test fixtures never leak into the shared global registry for
+// the rest of the test binary's life.
+func registerCollectorForTest(t *testing.T, name string, defaultEnabled bool, factory collectorFactory, excludeNodeTypes ...nodeType) {
+	t.Helper()
+
+	registerCollector(name, defaultEnabled, factory, excludeNodeTypes...)
+	t.Cleanup(func() {
+		collectorsMu.Lock()
+		defer collectorsMu.Unlock()
+
+		delete(collectors, name)
+	})
+}
+
+// registeredNames returns the "collector" label values present on the
+// mongodb_exporter_scrape_success metric family, i.e. every collector that
+// registerEnabledCollectors actually instantiated.
+func registeredNames(t *testing.T, registry *prometheus.Registry) map[string]bool {
+	t.Helper()
+
+	families, err := registry.Gather()
+	assert.NoError(t, err)
+
+	names := map[string]bool{}
+
+	for _, mf := range families {
+		if mf.GetName() != "mongodb_exporter_scrape_success" {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "collector" {
+					names[l.GetValue()] = true
+				}
+			}
+		}
+	}
+
+	return names
+}
+
+func TestRegisterEnabledCollectorsFiltering(t *testing.T) {
+	registerCollectorForTest(t, "test-default-on", true, func(collectorOpts) Collector { return noopCollector{} })
+	registerCollectorForTest(t, "test-default-off", false, func(collectorOpts) Collector { return noopCollector{} })
+	registerCollectorForTest(t, "test-mongos-only", true, func(collectorOpts) Collector { return noopCollector{} }, typeMongod)
+
+	registry := prometheus.NewRegistry()
+	registerEnabledCollectors(registry, collectorOpts{nodeType: typeMongos}, map[string]bool{
+		"test-default-off": true,  // explicit override turns a default-off collector on
+		"test-default-on":  false, // explicit override turns a default-on collector off
+	})
+
+	got := registeredNames(t, registry)
+
+	assert.True(t, got["test-default-off"], "enabled=true override must register a default-off collector")
+	assert.False(t, got["test-default-on"], "enabled=false override must skip a default-on collector")
+	assert.True(t, got["test-mongos-only"], "collector should run on node types not in its exclude list")
+}
+
+func TestRegisterEnabledCollectorsExcludesNodeType(t *testing.T) {
+	registerCollectorForTest(t, "test-excluded-on-mongod", true, func(collectorOpts) Collector { return noopCollector{} }, typeMongod)
+
+	registry := prometheus.NewRegistry()
+	registerEnabledCollectors(registry, collectorOpts{nodeType: typeMongod}, nil)
+
+	got := registeredNames(t, registry)
+
+	assert.False(t, got["test-excluded-on-mongod"], "collector must be skipped against an excluded node type")
+}
+
+func TestRegisterEnabledCollectorsDefaultOffWithoutOverride(t *testing.T) {
+	registerCollectorForTest(t, "test-default-off-no-override", false, func(collectorOpts) Collector { return noopCollector{} })
+
+	registry := prometheus.NewRegistry()
+	registerEnabledCollectors(registry, collectorOpts{}, nil)
+
+	got := registeredNames(t, registry)
+
+	assert.False(t, got["test-default-off-no-override"], "a default-off collector with no enabled entry must not run")
+}
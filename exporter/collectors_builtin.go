@@ -0,0 +1,105 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// promCollectorAdapter wraps an already-built prometheus.Collector (the
+// collstats/indexstats/diagnosticData/dbstats/top/replSetGetStatus
+// collectors, which predate registerCollector and speak Describe/Collect
+// directly) as a Collector, so they can be driven through the same
+// registerCollector path as newer, Update()-based collectors.
+type promCollectorAdapter struct {
+	name      string
+	collector prometheus.Collector
+}
+
+func (a promCollectorAdapter) Name() string { return a.name }
+
+func (a promCollectorAdapter) Update(ch chan<- prometheus.Metric) error {
+	a.collector.Collect(ch)
+
+	return nil
+}
+
+// init registers the exporter's built-in collectors. Each one keeps its
+// existing struct and Collect logic; only discovery (this file) and
+// enable/disable wiring (makeRegistry) go through registerCollector now.
+func init() { //nolint:gochecknoinits
+	registerCollector("collstats", false, func(co collectorOpts) Collector {
+		return promCollectorAdapter{name: "collstats", collector: &collstatsCollector{
+			ctx:             co.ctx,
+			client:          co.client,
+			collections:     co.collStatsCollections,
+			compatibleMode:  co.compatibleMode,
+			discoveringMode: co.discoveringMode,
+			logger:          co.logger,
+			topologyInfo:    co.topologyInfo,
+		}}
+	})
+
+	registerCollector("indexstats", false, func(co collectorOpts) Collector {
+		return promCollectorAdapter{name: "indexstats", collector: &indexstatsCollector{
+			ctx:             co.ctx,
+			client:          co.client,
+			collections:     co.indexStatsCollections,
+			discoveringMode: co.discoveringMode,
+			logger:          co.logger,
+			topologyInfo:    co.topologyInfo,
+		}}
+	})
+
+	registerCollector("diagnosticdata", false, func(co collectorOpts) Collector {
+		return promCollectorAdapter{name: "diagnosticdata", collector: &diagnosticDataCollector{
+			ctx:            co.ctx,
+			client:         co.client,
+			compatibleMode: co.compatibleMode,
+			logger:         co.logger,
+			topologyInfo:   co.topologyInfo,
+		}}
+	})
+
+	registerCollector("dbstats", false, func(co collectorOpts) Collector {
+		return promCollectorAdapter{name: "dbstats", collector: &dbstatsCollector{
+			ctx:            co.ctx,
+			client:         co.client,
+			compatibleMode: co.compatibleMode,
+			logger:         co.logger,
+			topologyInfo:   co.topologyInfo,
+		}}
+	})
+
+	registerCollector("top", false, func(co collectorOpts) Collector {
+		return promCollectorAdapter{name: "top", collector: &topCollector{
+			ctx:            co.ctx,
+			client:         co.client,
+			compatibleMode: co.compatibleMode,
+			logger:         co.logger,
+			topologyInfo:   co.topologyInfo,
+		}}
+	}, typeMongos)
+
+	registerCollector("replsetgetstatus", false, func(co collectorOpts) Collector {
+		return promCollectorAdapter{name: "replsetgetstatus", collector: &replSetGetStatusCollector{
+			ctx:            co.ctx,
+			client:         co.client,
+			compatibleMode: co.compatibleMode,
+			logger:         co.logger,
+			topologyInfo:   co.topologyInfo,
+		}}
+	}, typeMongos)
+}
@@ -20,16 +20,18 @@ package exporter
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/promlog"
+	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/exporter-toolkit/web"
-	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -39,11 +41,19 @@ type Exporter struct {
 	path                  string
 	client                *mongo.Client
 	clientMu              sync.Mutex
-	logger                *logrus.Logger
+	logger                *slog.Logger
 	opts                  *Opts
 	webListenAddress      string
 	lock                  *sync.Mutex
 	totalCollectionsCount int
+	probeClients          *probeClientCache
+	sdam                  *sdamMonitor
+	// globalSDAM is the sdamMonitor for the current GlobalConnPool
+	// connection generation, guarded by clientMu alongside client itself.
+	// Unused when GlobalConnPool is false; see currentSDAM.
+	globalSDAM    *sdamMonitor
+	clientHealthy bool
+	reconnecting  bool
 }
 
 // Opts holds new exporter options.
@@ -58,6 +68,23 @@ type Opts struct {
 	DiscoveringMode        bool
 	GlobalConnPool         bool
 
+	// AuthModules maps auth_module query parameter values (on the /probe
+	// endpoint) to URI templates, so probe requests don't need to carry
+	// credentials in the query string. See LoadAuthModules.
+	AuthModules map[string]AuthModule
+
+	// ProbeTimeout bounds a single /probe request: dialing (often TLS, often
+	// over the internet to Atlas/DocumentDB) plus running all enabled
+	// collectors. Zero means defaultProbeTimeout. Overridden per-request by
+	// Prometheus's X-Prometheus-Scrape-Timeout-Seconds header when present.
+	ProbeTimeout time.Duration
+
+	// Collectors overrides the default-enabled state of collectors
+	// registered via registerCollector, keyed by name
+	// (--collector.<name> / --no-collector.<name>). A name absent from
+	// the map falls back to the collector's own default.
+	Collectors map[string]bool
+
 	CollectAll             bool
 	EnableDBStats          bool
 	EnableDiagnosticData   bool
@@ -67,10 +94,27 @@ type Opts struct {
 	EnableCollStats        bool
 
 	IndexStatsCollections []string
-	Logger                *logrus.Logger
+	Logger                *slog.Logger
 	Path                  string
 	URI                   string
 	WebListenAddress      string
+
+	// WebConfigFile points to an exporter-toolkit web config YAML file
+	// enabling TLS, client-cert auth and/or bcrypt-hashed HTTP basic auth
+	// on the exporter's own listener. Empty means plain HTTP, as before.
+	WebConfigFile string
+
+	// TLS configures the connection to MongoDB itself, so deployments
+	// requiring server or client certificate validation don't need to
+	// stuff everything into the URI.
+	TLS TLSOpts
+	// AuthMechanism selects a non-default MongoDB auth mechanism, e.g.
+	// "MONGODB-X509" or "GSSAPI". Ignored when CredentialProvider is set.
+	AuthMechanism string
+	// CredentialProvider, when set, takes precedence over AuthMechanism
+	// and URI-embedded credentials, resolving credentials fresh on every
+	// connect (e.g. Atlas IAM or Vault-issued tokens).
+	CredentialProvider CredentialProvider
 }
 
 var (
@@ -85,7 +129,7 @@ func New(opts *Opts) *Exporter {
 	}
 
 	if opts.Logger == nil {
-		opts.Logger = logrus.New()
+		opts.Logger = slog.Default()
 	}
 
 	ctx := context.Background()
@@ -97,12 +141,14 @@ func New(opts *Opts) *Exporter {
 		webListenAddress:      opts.WebListenAddress,
 		lock:                  &sync.Mutex{},
 		totalCollectionsCount: -1, // not calculated yet. waiting the db connection.
+		probeClients:          newProbeClientCache(),
+		sdam:                  newSDAMMonitor(),
 	}
 	// Try initial connect. Connection will be retried with every scrape.
 	go func() {
 		_, err := exp.getClient(ctx)
 		if err != nil {
-			exp.logger.Errorf("Cannot connect to MongoDB: %v", err)
+			exp.logger.Error("Cannot connect to MongoDB", "err", err)
 		}
 	}()
 
@@ -116,7 +162,7 @@ func (e *Exporter) getTotalCollectionsCount() int {
 	return e.totalCollectionsCount
 }
 
-func (e *Exporter) makeRegistry(ctx context.Context, client *mongo.Client, topologyInfo labelsGetter) *prometheus.Registry {
+func (e *Exporter) makeRegistry(ctx context.Context, client *mongo.Client, topologyInfo labelsGetter, totalCollectionsCount int) *prometheus.Registry {
 	registry := prometheus.NewRegistry()
 
 	gc := generalCollector{
@@ -132,132 +178,233 @@ func (e *Exporter) makeRegistry(ctx context.Context, client *mongo.Client, topol
 
 	nodeType, err := getNodeType(ctx, client)
 	if err != nil {
-		e.logger.Errorf("Cannot get node type to check if this is a mongos: %s", err)
+		e.logger.Error("Cannot get node type to check if this is a mongos", "err", err)
 	}
 
 	// enable collectors like collstats and indexstats depending on the number of collections
-	// present in the database.
+	// present in the database. totalCollectionsCount is scoped to whichever
+	// target this registry is being built for (the primary URI or one
+	// /probe target), never a different target's count.
 	limitsOk := false
 	if e.opts.CollStatsLimit == 0 || // Unlimited
-		(e.getTotalCollectionsCount() > 0 && e.getTotalCollectionsCount() < e.opts.CollStatsLimit) {
+		(totalCollectionsCount > 0 && totalCollectionsCount < e.opts.CollStatsLimit) {
 		limitsOk = true
 	}
 
+	// e.opts is shared across every concurrent makeRegistry call (the
+	// primary Handler and any number of simultaneous ProbeHandler
+	// goroutines), so the CollectAll-derived state is snapshotted into
+	// locals here instead of being written back into e.opts.
+	discoveringMode := e.opts.DiscoveringMode
+	enableDiagnosticData := e.opts.EnableDiagnosticData
+	enableDBStats := e.opts.EnableDBStats
+	enableTopMetrics := e.opts.EnableTopMetrics
+	enableReplicasetStatus := e.opts.EnableReplicasetStatus
+
 	if e.opts.CollectAll {
 		if len(e.opts.CollStatsNamespaces) == 0 {
-			e.opts.DiscoveringMode = true
+			discoveringMode = true
 		}
-		e.opts.EnableDiagnosticData = true
-		e.opts.EnableDBStats = true
-		e.opts.EnableTopMetrics = true
-		e.opts.EnableReplicasetStatus = true
+		enableDiagnosticData = true
+		enableDBStats = true
+		enableTopMetrics = true
+		enableReplicasetStatus = true
 	}
 
-	// if we manually set the collection names we want or auto discovery is set
-	if (len(e.opts.CollStatsNamespaces) > 0 || e.opts.DiscoveringMode) && e.opts.EnableCollStats && limitsOk {
-		cc := collstatsCollector{
-			ctx:             ctx,
-			client:          client,
-			collections:     e.opts.CollStatsNamespaces,
-			compatibleMode:  e.opts.CompatibleMode,
-			discoveringMode: e.opts.DiscoveringMode,
-			logger:          e.opts.Logger,
-			topologyInfo:    topologyInfo,
-		}
-		registry.MustRegister(&cc)
+	// Built-in collectors (collstats, indexstats, diagnosticdata, dbstats,
+	// top, replsetgetstatus) and any community collectors registered via
+	// registerCollector are looked up dynamically, so this function
+	// doesn't need to know about them individually. The gating that used
+	// to be inline per collector (namespace lists, discovery mode,
+	// CollStatsLimit) is folded into the per-name "enabled" state below;
+	// an explicit e.opts.Collectors entry always wins over it.
+	enabled := map[string]bool{
+		"diagnosticdata":   enableDiagnosticData,
+		"dbstats":          enableDBStats,
+		"top":              enableTopMetrics,
+		"replsetgetstatus": enableReplicasetStatus,
+		"collstats": e.opts.EnableCollStats && limitsOk &&
+			(len(e.opts.CollStatsNamespaces) > 0 || discoveringMode),
+		"indexstats": e.opts.EnableIndexStats && limitsOk &&
+			(len(e.opts.IndexStatsCollections) > 0 || discoveringMode),
 	}
-
-	// if we manually set the collection names we want or auto discovery is set
-	if (len(e.opts.IndexStatsCollections) > 0 || e.opts.DiscoveringMode) && e.opts.EnableIndexStats && limitsOk {
-		ic := indexstatsCollector{
-			ctx:             ctx,
-			client:          client,
-			collections:     e.opts.IndexStatsCollections,
-			discoveringMode: e.opts.DiscoveringMode,
-			logger:          e.opts.Logger,
-			topologyInfo:    topologyInfo,
-		}
-		registry.MustRegister(&ic)
+	for name, on := range e.opts.Collectors {
+		enabled[name] = on
 	}
 
-	if e.opts.EnableDiagnosticData {
-		ddc := diagnosticDataCollector{
-			ctx:            ctx,
-			client:         client,
-			compatibleMode: e.opts.CompatibleMode,
-			logger:         e.opts.Logger,
-			topologyInfo:   topologyInfo,
-		}
-		registry.MustRegister(&ddc)
-	}
+	registerEnabledCollectors(registry, collectorOpts{
+		ctx:                   ctx,
+		client:                client,
+		compatibleMode:        e.opts.CompatibleMode,
+		discoveringMode:       discoveringMode,
+		collStatsCollections:  e.opts.CollStatsNamespaces,
+		indexStatsCollections: e.opts.IndexStatsCollections,
+		logger:                e.opts.Logger,
+		topologyInfo:          topologyInfo,
+		nodeType:              nodeType,
+	}, enabled)
 
-	if e.opts.EnableDBStats {
-		cc := dbstatsCollector{
-			ctx:            ctx,
-			client:         client,
-			compatibleMode: e.opts.CompatibleMode,
-			logger:         e.opts.Logger,
-			topologyInfo:   topologyInfo,
-		}
-		registry.MustRegister(&cc)
+	return registry
+}
+
+// connectFn, pingFn and disconnectFn are indirections over connect and the
+// driver's Ping/Disconnect methods, so tests can drive getGlobalClient's
+// health-flip/reconnect logic and probeClientCache's LRU eviction without a
+// live MongoDB server. Production code always uses the package defaults.
+var (
+	connectFn = connect
+	pingFn    = func(ctx context.Context, client *mongo.Client) error {
+		return client.Ping(ctx, nil)
 	}
+	disconnectFn = func(ctx context.Context, client *mongo.Client) error {
+		return client.Disconnect(ctx)
+	}
+)
 
-	if e.opts.EnableTopMetrics && nodeType != typeMongos {
-		tc := topCollector{
-			ctx:            ctx,
-			client:         client,
-			compatibleMode: e.opts.CompatibleMode,
-			logger:         e.opts.Logger,
-			topologyInfo:   topologyInfo,
-		}
-		registry.MustRegister(&tc)
+func (e *Exporter) getClient(ctx context.Context) (*mongo.Client, error) {
+	if e.opts.GlobalConnPool {
+		return e.getGlobalClient(ctx)
 	}
 
-	// replSetGetStatus is not supported through mongos
-	if e.opts.EnableReplicasetStatus && nodeType != typeMongos {
-		rsgsc := replSetGetStatusCollector{
-			ctx:            ctx,
-			client:         client,
-			compatibleMode: e.opts.CompatibleMode,
-			logger:         e.opts.Logger,
-			topologyInfo:   topologyInfo,
-		}
-		registry.MustRegister(&rsgsc)
+	// !e.opts.GlobalConnPool: create new client for every scrape
+	client, err := connectFn(ctx, e.connectOpts(e.sdam))
+	if err != nil {
+		return nil, err
 	}
 
-	return registry
+	return client, nil
 }
 
-func (e *Exporter) getClient(ctx context.Context) (*mongo.Client, error) {
-	if e.opts.GlobalConnPool {
-		// get global client. Maybe it must be initialized first.
-		// Initialization is retried with every scrape until it succeeds once.
-		e.clientMu.Lock()
-		defer e.clientMu.Unlock()
+// getGlobalClient returns the long-lived shared client used when
+// GlobalConnPool is set. Unlike caching the client forever, it pings it on
+// every call and, if the ping fails, marks it unhealthy and kicks off
+// exactly one background reconnect (guarded by e.reconnecting) instead of
+// letting every concurrent scrape race to redial. Callers get the
+// stale-but-still-valid client back immediately; e.clientHealthy tells
+// Handler whether to report up=0 for this scrape.
+func (e *Exporter) getGlobalClient(ctx context.Context) (*mongo.Client, error) {
+	e.clientMu.Lock()
 
-		// if client is already initialized, return it
-		if e.client != nil {
-			return e.client, nil
-		}
+	if e.client == nil {
+		sdam := newSDAMMonitor()
 
-		client, err := connect(ctx, e.opts.URI, e.opts.DirectConnect)
+		client, err := connectFn(ctx, e.connectOpts(sdam))
 		if err != nil {
+			e.clientMu.Unlock()
+
 			return nil, err
 		}
+
 		e.client = client
+		e.globalSDAM = sdam
+		e.clientHealthy = true
+		e.clientMu.Unlock()
 
 		return client, nil
 	}
 
-	// !e.opts.GlobalConnPool: create new client for every scrape
-	client, err := connect(ctx, e.opts.URI, e.opts.DirectConnect)
-	if err != nil {
-		return nil, err
+	client := e.client
+	e.clientMu.Unlock()
+
+	if err := pingFn(ctx, client); err != nil {
+		e.clientMu.Lock()
+		e.clientHealthy = false
+
+		if !e.reconnecting {
+			e.reconnecting = true
+
+			go e.reconnectGlobalClient()
+		}
+		e.clientMu.Unlock()
+
+		return client, nil
 	}
 
+	e.clientMu.Lock()
+	e.clientHealthy = true
+	e.clientMu.Unlock()
+
 	return client, nil
 }
 
+// reconnectGlobalClient rebuilds e.client in the background. Only one
+// instance of this ever runs at a time, guarded by e.reconnecting. It dials
+// with a fresh sdamMonitor rather than reusing e.globalSDAM, so the old
+// client's background disconnect (below) reports its ServerClosedEvent
+// against the outgoing monitor and can't wipe server/pool state the new
+// client has already reported against the incoming one.
+func (e *Exporter) reconnectGlobalClient() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sdam := newSDAMMonitor()
+
+	newClient, err := connectFn(ctx, e.connectOpts(sdam))
+
+	e.clientMu.Lock()
+	defer e.clientMu.Unlock()
+
+	if err != nil {
+		e.logger.Error("Cannot reconnect to MongoDB", "err", err)
+		e.reconnecting = false
+
+		return
+	}
+
+	oldClient := e.client
+	e.client = newClient
+	e.globalSDAM = sdam
+	e.clientHealthy = true
+	e.reconnecting = false
+
+	go func() {
+		_ = disconnectFn(context.Background(), oldClient)
+	}()
+}
+
+// isHealthy reports whether the global client is currently believed to be
+// connected. Only meaningful when GlobalConnPool is set; the per-scrape
+// connection path reports health via getClient's returned error instead.
+func (e *Exporter) isHealthy() bool {
+	e.clientMu.Lock()
+	defer e.clientMu.Unlock()
+
+	return e.clientHealthy
+}
+
+// connectOpts builds the mongoConnectOpts for the exporter's primary
+// MongoDB connection (e.opts.URI), shared by getClient and the initial
+// connect in New. sdam is passed in rather than always reading e.sdam so
+// each global-pool connection generation can get its own monitor; see
+// currentSDAM.
+func (e *Exporter) connectOpts(sdam *sdamMonitor) mongoConnectOpts {
+	return mongoConnectOpts{
+		dsn:                e.opts.URI,
+		directConnect:      e.opts.DirectConnect,
+		tls:                e.opts.TLS,
+		authMechanism:      e.opts.AuthMechanism,
+		credentialProvider: e.opts.CredentialProvider,
+		sdam:               sdam,
+	}
+}
+
+// currentSDAM returns the sdamMonitor to report pool/topology metrics from
+// for the exporter's primary connection. With GlobalConnPool, this is the
+// current connection generation's monitor, swapped by reconnectGlobalClient
+// on every reconnect so the outgoing client's background disconnect can't
+// clear state the incoming client already reported. Without GlobalConnPool,
+// every scrape's client shares the single e.sdam field.
+func (e *Exporter) currentSDAM() *sdamMonitor {
+	if !e.opts.GlobalConnPool {
+		return e.sdam
+	}
+
+	e.clientMu.Lock()
+	defer e.clientMu.Unlock()
+
+	return e.globalSDAM
+}
+
 // Handler returns an http.Handler that serves metrics. Can be used instead of
 // Run for hooking up custom HTTP servers.
 func (e *Exporter) Handler() http.Handler {
@@ -268,7 +415,7 @@ func (e *Exporter) Handler() http.Handler {
 
 		client, err := e.getClient(ctx)
 		if err != nil {
-			e.logger.Errorf("Cannot connect to MongoDB: %v", err)
+			e.logger.Error("Cannot connect to MongoDB", "err", err)
 		}
 
 		if client != nil && e.getTotalCollectionsCount() < 0 {
@@ -284,9 +431,9 @@ func (e *Exporter) Handler() http.Handler {
 		if !e.opts.GlobalConnPool {
 			defer func() {
 				if client != nil {
-					err := client.Disconnect(ctx)
+					err := disconnectFn(ctx, client)
 					if err != nil {
-						e.logger.Errorf("Cannot disconnect client: %v", err)
+						e.logger.Error("Cannot disconnect client", "err", err)
 					}
 				}
 			}()
@@ -297,7 +444,7 @@ func (e *Exporter) Handler() http.Handler {
 		if client != nil {
 			ti, err = newTopologyInfo(ctx, client)
 			if err != nil {
-				e.logger.Errorf("Cannot get topology info: %v", err)
+				e.logger.Error("Cannot get topology info", "err", err)
 				http.Error(
 					w,
 					"An error has occurred while getting topology info:\n\n"+err.Error(),
@@ -306,7 +453,8 @@ func (e *Exporter) Handler() http.Handler {
 			}
 		}
 
-		registry := e.makeRegistry(ctx, client, ti)
+		registry := e.makeRegistry(ctx, client, ti, e.getTotalCollectionsCount())
+		registry.MustRegister(&sdamCollector{monitor: e.currentSDAM()})
 
 		var gatherers prometheus.Gatherers
 
@@ -318,32 +466,99 @@ func (e *Exporter) Handler() http.Handler {
 		// Delegate http serving to Prometheus client library, which will call collector.Collect.
 		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{
 			ErrorHandling: promhttp.ContinueOnError,
-			ErrorLog:      e.logger,
+			ErrorLog:      slogErrorLogger{e.logger},
 		})
 
 		h.ServeHTTP(w, r)
 	})
 }
 
-// Run starts the exporter.
+// slogErrorLogger adapts a *slog.Logger to the promhttp.Logger interface
+// (Println(v ...interface{})) so promhttp.HandlerOpts.ErrorLog keeps working
+// without pulling logrus back in.
+type slogErrorLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogErrorLogger) Println(v ...interface{}) {
+	l.logger.Error(fmt.Sprint(v...))
+}
+
+// Run starts the exporter and blocks until it shuts down, either because the
+// HTTP server failed to start or because a SIGINT/SIGTERM was received.
 func (e *Exporter) Run() {
 	server := &http.Server{
 		Addr:    e.webListenAddress,
 		Handler: e.Handler(),
 	}
 
-	// TODO: tls, basic auth support, etc.
-	if err := web.ListenAndServe(server, "", promlog.New(&promlog.Config{})); err != nil {
-		e.logger.Errorf("error starting server: %v", err)
-		os.Exit(1)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- web.ListenAndServe(server, &web.FlagConfig{
+			WebConfigFile: &e.opts.WebConfigFile,
+		}, promslog.New(&promslog.Config{}))
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		if err != nil && err != http.ErrServerClosed {
+			e.logger.Error("error starting server", "err", err)
+			os.Exit(1)
+		}
+	case s := <-sig:
+		e.logger.Info("shutting down", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			e.logger.Error("error during graceful shutdown", "err", err)
+		}
 	}
 }
 
-func connect(ctx context.Context, dsn string, directConnect bool) (*mongo.Client, error) {
-	clientOpts := options.Client().ApplyURI(dsn)
-	clientOpts.SetDirect(directConnect)
+// mongoConnectOpts carries everything connect needs to dial a MongoDB
+// target, whether the exporter's primary one or an ad-hoc /probe target.
+type mongoConnectOpts struct {
+	dsn                string
+	directConnect      bool
+	tls                TLSOpts
+	authMechanism      string
+	credentialProvider CredentialProvider
+	sdam               *sdamMonitor
+}
+
+func connect(ctx context.Context, co mongoConnectOpts) (*mongo.Client, error) {
+	clientOpts := options.Client().ApplyURI(co.dsn)
+	clientOpts.SetDirect(co.directConnect)
 	clientOpts.SetAppName("mongodb_exporter")
 
+	if co.tls.enabled() {
+		tlsConfig, err := buildTLSConfig(co.tls)
+		if err != nil {
+			return nil, err
+		}
+
+		clientOpts.SetTLSConfig(tlsConfig)
+	}
+
+	cred, ok, err := resolveCredential(ctx, co.credentialProvider, co.authMechanism)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		clientOpts.SetAuth(cred)
+	}
+
+	if co.sdam != nil {
+		clientOpts.SetServerMonitor(co.sdam.serverMonitor())
+		clientOpts.SetPoolMonitor(co.sdam.poolMonitor())
+	}
+
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		return nil, fmt.Errorf("invalid MongoDB options: %w", err)
@@ -0,0 +1,97 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TLSOpts configures the TLS connection the exporter makes to MongoDB,
+// independent of the exporter's own HTTP listener (see Opts.WebConfigFile).
+type TLSOpts struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+func (t TLSOpts) enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != "" || t.InsecureSkipVerify
+}
+
+// CredentialProvider resolves MongoDB credentials on demand, so short-lived
+// credentials (Atlas IAM, Vault-issued secrets) can be refreshed between
+// scrapes instead of being fixed for the exporter's lifetime.
+type CredentialProvider interface {
+	Credential(ctx context.Context) (options.Credential, error)
+}
+
+func buildTLSConfig(opts TLSOpts) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify} //nolint:gosec
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cannot parse CA file %q", opts.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// resolveCredential builds the options.Credential to authenticate with,
+// preferring a CredentialProvider (for refreshable, short-lived creds) over
+// a static AuthMechanism. ok is false when neither is set, in which case
+// credentials embedded in the connection URI are used as-is.
+func resolveCredential(ctx context.Context, credentialProvider CredentialProvider, authMechanism string) (cred options.Credential, ok bool, err error) {
+	if credentialProvider != nil {
+		cred, err = credentialProvider.Credential(ctx)
+		if err != nil {
+			return options.Credential{}, false, fmt.Errorf("cannot resolve MongoDB credentials: %w", err)
+		}
+
+		return cred, true, nil
+	}
+
+	if authMechanism != "" {
+		return options.Credential{AuthMechanism: authMechanism}, true, nil
+	}
+
+	return options.Credential{}, false, nil
+}
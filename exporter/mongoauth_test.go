@@ -0,0 +1,140 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestResolveCredentialNoneConfigured(t *testing.T) {
+	cred, ok, err := resolveCredential(context.Background(), nil, "")
+	assert.NoError(t, err)
+	assert.False(t, ok, "neither a provider nor an auth mechanism means credentials come from the URI")
+	assert.Equal(t, options.Credential{}, cred)
+}
+
+func TestResolveCredentialAuthMechanism(t *testing.T) {
+	cred, ok, err := resolveCredential(context.Background(), nil, "MONGODB-X509")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "MONGODB-X509", cred.AuthMechanism)
+}
+
+type fakeCredentialProvider struct {
+	cred options.Credential
+	err  error
+}
+
+func (f fakeCredentialProvider) Credential(context.Context) (options.Credential, error) {
+	return f.cred, f.err
+}
+
+func TestResolveCredentialProviderTakesPrecedenceOverAuthMechanism(t *testing.T) {
+	provider := fakeCredentialProvider{cred: options.Credential{Username: "atlas-iam-user"}}
+
+	cred, ok, err := resolveCredential(context.Background(), provider, "MONGODB-X509")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "atlas-iam-user", cred.Username, "a CredentialProvider must win over a static AuthMechanism")
+	assert.Empty(t, cred.AuthMechanism)
+}
+
+func TestResolveCredentialProviderError(t *testing.T) {
+	provider := fakeCredentialProvider{err: errors.New("vault: token expired")}
+
+	_, ok, err := resolveCredential(context.Background(), provider, "MONGODB-X509")
+	assert.False(t, ok)
+	assert.ErrorContains(t, err, "vault: token expired")
+}
+
+func TestBuildTLSConfigInsecureSkipVerify(t *testing.T) {
+	cfg, err := buildTLSConfig(TLSOpts{InsecureSkipVerify: true})
+	assert.NoError(t, err)
+	assert.True(t, cfg.InsecureSkipVerify)
+}
+
+func TestBuildTLSConfigLoadsCAAndClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM := generateSelfSignedCert(t)
+
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client.key")
+	assert.NoError(t, os.WriteFile(certPath, certPEM, 0o600))
+	assert.NoError(t, os.WriteFile(keyPath, keyPEM, 0o600))
+
+	cfg, err := buildTLSConfig(TLSOpts{CAFile: certPath, CertFile: certPath, KeyFile: keyPath})
+	assert.NoError(t, err)
+	assert.NotNil(t, cfg.RootCAs)
+	assert.Len(t, cfg.Certificates, 1)
+}
+
+func TestBuildTLSConfigMissingCAFile(t *testing.T) {
+	_, err := buildTLSConfig(TLSOpts{CAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err)
+}
+
+func TestBuildTLSConfigBadCertKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, _ := generateSelfSignedCert(t)
+
+	certPath := filepath.Join(dir, "client.pem")
+	assert.NoError(t, os.WriteFile(certPath, certPEM, 0o600))
+
+	_, err := buildTLSConfig(TLSOpts{CertFile: certPath, KeyFile: certPath})
+	assert.Error(t, err, "a cert file used as its own key must fail to parse")
+}
+
+// generateSelfSignedCert returns a freshly generated self-signed certificate
+// and its private key, PEM-encoded, for tests that need TLS material without
+// a fixture file on disk.
+func generateSelfSignedCert(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mongodb-exporter-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM
+}
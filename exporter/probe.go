@@ -0,0 +1,503 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/yaml.v2"
+)
+
+// maxProbeClients bounds the number of ad-hoc mongo.Client connections the
+// probe handler keeps alive at once. Oldest idle targets are disconnected
+// and evicted first.
+const maxProbeClients = 100
+
+// defaultProbeTimeout is used when Opts.ProbeTimeout is unset and the
+// request carries no X-Prometheus-Scrape-Timeout-Seconds header.
+const defaultProbeTimeout = 10 * time.Second
+
+// probeTimeout returns the deadline for a single /probe request: Prometheus's
+// X-Prometheus-Scrape-Timeout-Seconds header, the way blackbox_exporter
+// honors it, if present and valid; otherwise e.opts.ProbeTimeout; otherwise
+// defaultProbeTimeout. A cache-miss probe must dial, authenticate and run
+// every enabled collector against a brand-new connection, so it needs much
+// more headroom than the always-already-connected /metrics path.
+func (e *Exporter) probeTimeout(r *http.Request) time.Duration {
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+
+	if e.opts.ProbeTimeout > 0 {
+		return e.opts.ProbeTimeout
+	}
+
+	return defaultProbeTimeout
+}
+
+// AuthModule holds the URI template and connection settings used to reach a
+// target via the /probe endpoint without exposing credentials in the query
+// string. "{target}" in URITemplate is replaced with the target= value.
+type AuthModule struct {
+	URITemplate   string `yaml:"uri_template"`
+	DirectConnect bool   `yaml:"direct_connect"`
+}
+
+type authModulesConfig struct {
+	AuthModules map[string]AuthModule `yaml:"auth_modules"`
+}
+
+// LoadAuthModules reads a YAML file mapping auth_module names to connection
+// templates, as referenced by the auth_module= query parameter on /probe.
+func LoadAuthModules(path string) (map[string]AuthModule, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read auth modules file: %w", err)
+	}
+
+	var cfg authModulesConfig
+	if err := yaml.Unmarshal(buf, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse auth modules file: %w", err)
+	}
+
+	return cfg.AuthModules, nil
+}
+
+// probeClientCache is a bounded, LRU-evicting cache of ad-hoc mongo.Client
+// connections keyed by probe target, so a fleet of Atlas/DocumentDB
+// instances can be scraped from one exporter without reconnecting on every
+// request nor leaking one connection per target forever.
+type probeClientCache struct {
+	mu       sync.Mutex
+	elements map[string]*list.Element
+	order    *list.List
+	// calls singleflights concurrent first-connects for the same target,
+	// so two simultaneous probes of a target not yet in the cache dial
+	// once between them instead of each dialing and racing to insert
+	// (the loser's *mongo.Client would otherwise be overwritten in the
+	// cache and leaked, never Disconnect'd).
+	calls map[string]*probeConnectCall
+}
+
+// probeConnectCall is the in-flight (or completed) result of connecting to
+// one probe target, shared by every caller that arrives while it's running.
+type probeConnectCall struct {
+	wg     sync.WaitGroup
+	client *mongo.Client
+	sdam   *sdamMonitor
+	err    error
+}
+
+type probeClientCacheEntry struct {
+	target string
+	client *mongo.Client
+	// sdam is a per-target monitor, never shared with the exporter's
+	// primary connection or with any other probe target: SDAM state is
+	// keyed by server address, so a shared monitor would leak every
+	// target's topology into every other target's /probe scrape.
+	sdam *sdamMonitor
+	// totalCollectionsCount mirrors Exporter.totalCollectionsCount but
+	// scoped to this target alone, so CollStatsLimit gating in
+	// makeRegistry is never decided by a different target's collection
+	// count (or, worse, the primary exporter's, which may never have been
+	// computed in a pure multi-target deployment). -1 until computed.
+	totalCollectionsCount int
+	// refCount counts in-flight scrapes currently holding this entry via
+	// checkout, decremented by release. While it's positive, retire defers
+	// Disconnecting the client (setting pendingDisconnect) instead of
+	// closing it out from under a request that's still mid-query.
+	refCount int
+	// pendingDisconnect marks an entry that was replaced by a redial or
+	// LRU-evicted while still checked out; release disconnects it once
+	// refCount drops back to zero.
+	pendingDisconnect bool
+}
+
+func newProbeClientCache() *probeClientCache {
+	return &probeClientCache{
+		elements: make(map[string]*list.Element),
+		order:    list.New(),
+		calls:    make(map[string]*probeConnectCall),
+	}
+}
+
+// startCall registers the caller as the one responsible for connecting to
+// target, or, if another goroutine is already connecting to it, returns that
+// in-flight call instead so the caller can wait on it.
+func (c *probeClientCache) startCall(target string) (call *probeConnectCall, isLeader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if call, ok := c.calls[target]; ok {
+		return call, false
+	}
+
+	call = &probeConnectCall{}
+	call.wg.Add(1)
+	c.calls[target] = call
+
+	return call, true
+}
+
+// finishCall records the outcome of a connect attempt started by startCall,
+// inserting a successful result into the cache in the same critical section
+// that clears the singleflight entry. Doing the insert as a separate step
+// after unlocking (the previous approach) left a window where a new request
+// for the same not-yet-cached target would see an empty c.calls, become a
+// second "leader", and dial a redundant client that would then race this
+// one's put() -- exactly the leak the singleflight gate exists to prevent.
+//
+// The returned entry is already checked out (refCount 1) on behalf of the
+// caller that dialed client -- the caller must release it, via
+// probeClients.release, once done using it.
+func (c *probeClientCache) finishCall(target string, call *probeConnectCall, client *mongo.Client, sdam *sdamMonitor, err error) *probeClientCacheEntry {
+	call.client, call.sdam, call.err = client, sdam, err
+
+	var entry *probeClientCacheEntry
+
+	c.mu.Lock()
+	delete(c.calls, target)
+
+	var evicted *probeClientCacheEntry
+	if err == nil {
+		evicted = c.insertLocked(target, client, sdam)
+
+		if el, ok := c.elements[target]; ok {
+			entry = el.Value.(*probeClientCacheEntry) //nolint:forcetypeassert
+			entry.refCount++
+		}
+	}
+	c.mu.Unlock()
+
+	call.wg.Done()
+
+	c.retire(evicted)
+
+	return entry
+}
+
+// get returns target's cached client and sdamMonitor without taking a
+// checkout on it; used only where the caller isn't going to hold the client
+// across a scrape (e.g. tests probing cache membership). Production code
+// that will use the client should call checkout instead, so a concurrent
+// redial or LRU eviction can't Disconnect it out from under the request.
+func (c *probeClientCache) get(target string) (*mongo.Client, *sdamMonitor, bool) {
+	client, sdam, entry, ok := c.checkout(target)
+	if !ok {
+		return nil, nil, false
+	}
+	c.release(entry)
+
+	return client, sdam, true
+}
+
+// checkout returns target's cached client, sdamMonitor and backing entry,
+// incrementing the entry's refCount so retire defers Disconnecting it (via
+// pendingDisconnect) until the matching release call, instead of closing it
+// out from under an in-flight scrape. ok is false if target isn't cached.
+func (c *probeClientCache) checkout(target string) (*mongo.Client, *sdamMonitor, *probeClientCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.elements[target]
+	if !ok {
+		return nil, nil, nil, false
+	}
+	c.order.MoveToFront(el)
+
+	entry := el.Value.(*probeClientCacheEntry) //nolint:forcetypeassert
+	entry.refCount++
+
+	return entry.client, entry.sdam, entry, true
+}
+
+// release relinquishes a checkout obtained from checkout or finishCall. A
+// no-op if entry is nil, so callers can defer it unconditionally even when
+// no checkout was actually made (e.g. a failed connect). If entry was
+// replaced or evicted while still checked out, this may trigger its
+// deferred Disconnect.
+func (c *probeClientCache) release(entry *probeClientCacheEntry) {
+	if entry == nil {
+		return
+	}
+
+	c.mu.Lock()
+	entry.refCount--
+	disconnect := entry.pendingDisconnect && entry.refCount == 0
+	c.mu.Unlock()
+
+	if disconnect {
+		go func() {
+			_ = disconnectFn(context.Background(), entry.client)
+		}()
+	}
+}
+
+// getTotalCollectionsCount returns the cached collection count for target,
+// or -1 if target isn't cached or its count hasn't been computed yet.
+func (c *probeClientCache) getTotalCollectionsCount(target string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.elements[target]
+	if !ok {
+		return -1
+	}
+
+	return el.Value.(*probeClientCacheEntry).totalCollectionsCount //nolint:forcetypeassert
+}
+
+// setTotalCollectionsCount records target's collection count for future
+// probes. A no-op if target has since been evicted.
+func (c *probeClientCache) setTotalCollectionsCount(target string, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.elements[target]; ok {
+		el.Value.(*probeClientCacheEntry).totalCollectionsCount = count //nolint:forcetypeassert
+	}
+}
+
+// put inserts target's client into the cache without taking a checkout on
+// it, for tests that populate the cache directly; production code always
+// goes through finishCall, which checks out the entry it inserts.
+func (c *probeClientCache) put(target string, client *mongo.Client, sdam *sdamMonitor) {
+	c.mu.Lock()
+	evicted := c.insertLocked(target, client, sdam)
+	c.mu.Unlock()
+
+	c.retire(evicted)
+}
+
+// insertLocked inserts target's newly connected client, or replaces its
+// existing entry (e.g. a redial after a dead liveness ping) with a fresh
+// one that carries over totalCollectionsCount, or, if the cache is now over
+// capacity, evicts the oldest entry instead. Either way the entry being
+// displaced -- never the same object as the one just inserted, since it may
+// still be checked out under its own refCount -- is returned for the caller
+// to retire once c.mu is released. Callers must hold c.mu.
+func (c *probeClientCache) insertLocked(target string, client *mongo.Client, sdam *sdamMonitor) *probeClientCacheEntry {
+	if el, ok := c.elements[target]; ok {
+		old := el.Value.(*probeClientCacheEntry) //nolint:forcetypeassert
+		c.order.MoveToFront(el)
+		el.Value = &probeClientCacheEntry{
+			target:                target,
+			client:                client,
+			sdam:                  sdam,
+			totalCollectionsCount: old.totalCollectionsCount,
+		}
+
+		return old
+	}
+
+	el := c.order.PushFront(&probeClientCacheEntry{target: target, client: client, sdam: sdam, totalCollectionsCount: -1})
+	c.elements[target] = el
+
+	if c.order.Len() <= maxProbeClients {
+		return nil
+	}
+
+	oldest := c.order.Back()
+	evicted := oldest.Value.(*probeClientCacheEntry) //nolint:forcetypeassert
+	c.order.Remove(oldest)
+	delete(c.elements, evicted.target)
+
+	return evicted
+}
+
+// retire disconnects entry's client in the background, under its own
+// context rather than the inbound scrape's (which may already be at or near
+// its deadline), so eviction never blocks the current response. Mirrors
+// reconnectGlobalClient's handling of its old client. If entry is still
+// checked out (refCount > 0) -- another scrape is still using it -- the
+// Disconnect is deferred to the checkout holder's matching release call
+// instead, so it can't be closed out from under a request that's mid-query.
+func (c *probeClientCache) retire(entry *probeClientCacheEntry) {
+	if entry == nil {
+		return
+	}
+
+	c.mu.Lock()
+	inUse := entry.refCount > 0
+	if inUse {
+		entry.pendingDisconnect = true
+	}
+	c.mu.Unlock()
+
+	if inUse {
+		return
+	}
+
+	go func() {
+		_ = disconnectFn(context.Background(), entry.client)
+	}()
+}
+
+// getClientForTarget returns a client for an ad-hoc probe target, reusing a
+// cached connection when one still passes a liveness ping, along with the
+// sdamMonitor scoped to that target alone and the cache entry backing the
+// client. The caller must release the entry (via probeClients.release, a
+// no-op on nil) once done using the client, so a concurrent redial or LRU
+// eviction can't Disconnect it out from under an in-flight scrape.
+func (e *Exporter) getClientForTarget(ctx context.Context, target, authModule string) (*mongo.Client, *sdamMonitor, *probeClientCacheEntry, error) {
+	if client, sdam, entry, ok := e.probeClients.checkout(target); ok {
+		if pingFn(ctx, client) == nil {
+			return client, sdam, entry, nil
+		}
+
+		// Cached client is dead: release this checkout and fall through to
+		// redial as if this had been a cache miss, replacing the stale
+		// entry so future probes of this target stop hitting it. Mirrors
+		// getGlobalClient's ping-then-reconnect handling of a dead
+		// long-lived connection.
+		e.probeClients.release(entry)
+	}
+
+	call, isLeader := e.probeClients.startCall(target)
+	if !isLeader {
+		// Another goroutine is already (re)connecting to this target; wait
+		// for its result instead of dialing a second, redundant client.
+		call.wg.Wait()
+
+		if call.err != nil {
+			return nil, nil, nil, call.err
+		}
+
+		// Best-effort checkout of our own: the entry may already have been
+		// replaced or evicted by the time we get here, in which case we
+		// still return the leader's successfully connected client, just
+		// without refcount protection for this particular request.
+		_, _, entry, _ := e.probeClients.checkout(target)
+
+		return call.client, call.sdam, entry, nil
+	}
+
+	client, sdam, err := e.dialTarget(ctx, target, authModule)
+	entry := e.probeClients.finishCall(target, call, client, sdam, err)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return client, sdam, entry, nil
+}
+
+// dialTarget resolves the target's connection URI (via authModule if given)
+// and dials it, without touching the cache.
+func (e *Exporter) dialTarget(ctx context.Context, target, authModule string) (*mongo.Client, *sdamMonitor, error) {
+	uri := fmt.Sprintf("mongodb://%s", target)
+	directConnect := e.opts.DirectConnect
+
+	if authModule != "" {
+		module, ok := e.opts.AuthModules[authModule]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown auth_module %q", authModule)
+		}
+		uri = strings.ReplaceAll(module.URITemplate, "{target}", target)
+		directConnect = module.DirectConnect
+	}
+
+	sdam := newSDAMMonitor()
+
+	co := e.connectOpts(sdam)
+	co.dsn = uri
+	co.directConnect = directConnect
+
+	client, err := connectFn(ctx, co)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client, sdam, nil
+}
+
+// ProbeHandler returns an http.Handler implementing the Prometheus
+// multi-target exporter pattern: it dials the MongoDB instance named by the
+// target= query parameter on demand and returns metrics for that instance
+// only, so a single exporter process can cover a whole fleet of
+// SaaS-managed deployments (Atlas, DocumentDB) instead of one sidecar per
+// node.
+func (e *Exporter) ProbeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is missing", http.StatusBadRequest)
+
+			return
+		}
+
+		authModule := r.URL.Query().Get("auth_module")
+
+		ctx, cancel := context.WithTimeout(r.Context(), e.probeTimeout(r))
+		defer cancel()
+
+		client, targetSDAM, probeEntry, err := e.getClientForTarget(ctx, target, authModule)
+		if err != nil {
+			e.logger.Error("Cannot connect to MongoDB target", "target", target, "err", err)
+		}
+		defer e.probeClients.release(probeEntry)
+
+		totalCollectionsCount := e.probeClients.getTotalCollectionsCount(target)
+		if client != nil && totalCollectionsCount < 0 {
+			count, err := nonSystemCollectionsCount(ctx, client, nil, nil)
+			if err == nil {
+				e.probeClients.setTotalCollectionsCount(target, count)
+				totalCollectionsCount = count
+			}
+		}
+
+		var ti *topologyInfo
+		if client != nil {
+			ti, err = newTopologyInfo(ctx, client)
+			if err != nil {
+				e.logger.Error("Cannot get topology info for target", "target", target, "err", err)
+				http.Error(
+					w,
+					"An error has occurred while getting topology info:\n\n"+err.Error(),
+					http.StatusInternalServerError,
+				)
+
+				return
+			}
+		}
+
+		registry := e.makeRegistry(ctx, client, ti, totalCollectionsCount)
+		registry.MustRegister(&sdamCollector{monitor: targetSDAM})
+
+		gatherers := prometheus.Gatherers{registry}
+
+		h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{
+			ErrorHandling: promhttp.ContinueOnError,
+			ErrorLog:      slogErrorLogger{e.logger},
+		})
+
+		h.ServeHTTP(w, r)
+	})
+}
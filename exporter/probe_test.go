@@ -0,0 +1,129 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestProbeClientCacheEviction checks that once the cache grows past
+// maxProbeClients, the oldest untouched target is evicted and disconnected
+// in the background, while a recently touched target survives.
+func TestProbeClientCacheEviction(t *testing.T) {
+	clients := make(map[*mongo.Client]string, maxProbeClients+1)
+	newClient := func(target string) *mongo.Client {
+		client := &mongo.Client{}
+		clients[client] = target
+
+		return client
+	}
+
+	disconnected := make(chan string, 1)
+
+	orig := disconnectFn
+	disconnectFn = func(_ context.Context, client *mongo.Client) error {
+		disconnected <- clients[client]
+
+		return nil
+	}
+	defer func() { disconnectFn = orig }()
+
+	c := newProbeClientCache()
+
+	for i := 0; i < maxProbeClients; i++ {
+		target := fmt.Sprintf("host%d:27017", i)
+		c.put(target, newClient(target), nil)
+	}
+
+	// Touch host0 so it's no longer the least-recently-used entry.
+	_, _, ok := c.get("host0:27017")
+	assert.True(t, ok)
+
+	overflowTarget := "overflow:27017"
+	c.put(overflowTarget, newClient(overflowTarget), nil)
+
+	// put backgrounds the evicted client's disconnect (it must not block the
+	// calling scrape), so wait for it rather than asserting immediately.
+	select {
+	case target := <-disconnected:
+		assert.Equal(t, "host1:27017", target, "the least recently used target should be evicted")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for evicted client to be disconnected")
+	}
+
+	if _, _, ok := c.get("host0:27017"); !ok {
+		t.Error("recently touched target should still be cached")
+	}
+	if _, _, ok := c.get(overflowTarget); !ok {
+		t.Error("newly inserted target should be cached")
+	}
+	if _, _, ok := c.get("host1:27017"); ok {
+		t.Error("evicted target should no longer be cached")
+	}
+}
+
+// TestProbeClientCacheSingleflight checks that concurrent first-connects for
+// the same target only dial once (via the leader returned by startCall) and
+// that every waiter observes the leader's result instead of racing to
+// overwrite the cache entry with its own client.
+func TestProbeClientCacheSingleflight(t *testing.T) {
+	c := newProbeClientCache()
+	target := "shared:27017"
+
+	const followers = 9
+
+	call, isLeader := c.startCall(target)
+	assert.True(t, isLeader)
+
+	var wg sync.WaitGroup
+
+	results := make([]*mongo.Client, followers)
+
+	for i := 0; i < followers; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			joined, isLeader := c.startCall(target)
+			assert.False(t, isLeader, "a second caller for the same in-flight target must not become leader")
+
+			joined.wg.Wait()
+			results[i] = joined.client
+		}(i)
+	}
+
+	leaderClient := &mongo.Client{}
+	c.finishCall(target, call, leaderClient, nil, nil)
+
+	wg.Wait()
+
+	for _, got := range results {
+		assert.Same(t, leaderClient, got, "followers must observe the leader's client, never dial their own")
+	}
+
+	cached, _, ok := c.get(target)
+	assert.True(t, ok, "finishCall must insert the leader's result into the cache itself")
+	assert.Same(t, leaderClient, cached)
+}
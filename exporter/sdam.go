@@ -0,0 +1,141 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// sdamMonitor tracks SDAM (Server Discovery And Monitoring) and connection
+// pool events for a single mongo.Client, so the exporter can expose pool and
+// topology health as metrics instead of only logging them.
+type sdamMonitor struct {
+	mu sync.Mutex
+
+	serverTypes map[string]string // address -> server type, e.g. "RSPrimary"
+	checkedOut  map[string]int    // address -> checked-out connections
+	eventTotals map[string]uint64 // event type -> occurrences
+}
+
+func newSDAMMonitor() *sdamMonitor {
+	return &sdamMonitor{
+		serverTypes: make(map[string]string),
+		checkedOut:  make(map[string]int),
+		eventTotals: make(map[string]uint64),
+	}
+}
+
+// serverMonitor returns an event.ServerMonitor that records topology
+// changes for registration via options.Client().SetServerMonitor.
+func (m *sdamMonitor) serverMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		ServerDescriptionChanged: func(e *event.ServerDescriptionChangedEvent) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.serverTypes[e.Address.String()] = e.NewDescription.Kind.String()
+			m.eventTotals["server_description_changed"]++
+		},
+		ServerClosed: func(e *event.ServerClosedEvent) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			delete(m.serverTypes, e.Address.String())
+			delete(m.checkedOut, e.Address.String())
+			m.eventTotals["server_closed"]++
+		},
+	}
+}
+
+// poolMonitor returns an event.PoolMonitor that records checked-out
+// connection counts per server, for registration via
+// options.Client().SetPoolMonitor.
+func (m *sdamMonitor) poolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.eventTotals[string(e.Type)]++
+
+			switch e.Type {
+			case event.GetSucceeded:
+				m.checkedOut[e.Address]++
+			case event.ConnectionReturned:
+				if m.checkedOut[e.Address] > 0 {
+					m.checkedOut[e.Address]--
+				}
+			}
+		},
+	}
+}
+
+var (
+	poolCheckedOutDesc = prometheus.NewDesc(
+		"mongodb_exporter_pool_checkedout",
+		"Number of connections currently checked out of the pool, per server.",
+		[]string{"address"}, nil,
+	)
+	serverStateDesc = prometheus.NewDesc(
+		"mongodb_exporter_server_state",
+		"Current SDAM server type for a known server address (1 for the active type, 0 otherwise).",
+		[]string{"address", "type"}, nil,
+	)
+	sdamEventTotalDesc = prometheus.NewDesc(
+		"mongodb_exporter_sdam_event_total",
+		"Total number of SDAM and connection pool events observed, by event type.",
+		[]string{"event"}, nil,
+	)
+)
+
+// sdamCollector adapts an Exporter's sdamMonitor snapshot into a
+// prometheus.Collector, so pool/topology state is reported on every scrape
+// regardless of which MongoDB collectors ran. mongodb_up is already owned by
+// generalCollector; this collector must not also emit it.
+type sdamCollector struct {
+	monitor *sdamMonitor
+}
+
+func (c *sdamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolCheckedOutDesc
+	ch <- serverStateDesc
+	ch <- sdamEventTotalDesc
+}
+
+func (c *sdamCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.monitor == nil {
+		return
+	}
+
+	c.monitor.mu.Lock()
+	defer c.monitor.mu.Unlock()
+
+	for address, count := range c.monitor.checkedOut {
+		ch <- prometheus.MustNewConstMetric(poolCheckedOutDesc, prometheus.GaugeValue, float64(count), address)
+	}
+
+	for address, serverType := range c.monitor.serverTypes {
+		ch <- prometheus.MustNewConstMetric(serverStateDesc, prometheus.GaugeValue, 1, address, serverType)
+	}
+
+	for eventType, total := range c.monitor.eventTotals {
+		ch <- prometheus.MustNewConstMetric(sdamEventTotalDesc, prometheus.CounterValue, float64(total), eventType)
+	}
+}
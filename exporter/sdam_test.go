@@ -0,0 +1,145 @@
+// mongodb_exporter
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+
+package exporter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestSDAMCollector checks that sdamCollector reflects a snapshot of the
+// underlying sdamMonitor's state. mongodb_up stays generalCollector's alone;
+// sdamCollector must not also emit it.
+func TestSDAMCollector(t *testing.T) {
+	monitor := newSDAMMonitor()
+	monitor.serverTypes["localhost:27017"] = "RSPrimary"
+	monitor.checkedOut["localhost:27017"] = 3
+	monitor.eventTotals["server_description_changed"] = 2
+
+	collector := &sdamCollector{monitor: monitor}
+
+	ch := make(chan prometheus.Metric, 16)
+	collector.Collect(ch)
+	close(ch)
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	assert.Len(t, metrics, 3, "checked-out + server state + event total")
+
+	var checkedOut dto.Metric
+	assert.NoError(t, metrics[0].Write(&checkedOut))
+	assert.Equal(t, poolCheckedOutDesc.String(), metrics[0].Desc().String())
+	assert.Equal(t, 3.0, checkedOut.GetGauge().GetValue())
+}
+
+// TestSDAMCollectorNilMonitor checks that a nil monitor (no client ever
+// connected for this target) reports no metrics instead of panicking.
+func TestSDAMCollectorNilMonitor(t *testing.T) {
+	collector := &sdamCollector{monitor: nil}
+
+	ch := make(chan prometheus.Metric, 1)
+	collector.Collect(ch)
+	close(ch)
+
+	_, ok := <-ch
+	assert.False(t, ok, "nil monitor must not emit any metrics")
+}
+
+// TestGetGlobalClientHealthFlip checks that getGlobalClient flips
+// clientHealthy to false the first time pingFn fails, kicks off exactly one
+// background reconnect, and flips clientHealthy back to true once that
+// reconnect (via connectFn) succeeds.
+func TestGetGlobalClientHealthFlip(t *testing.T) {
+	origPing, origConnect := pingFn, connectFn
+	defer func() { pingFn, connectFn = origPing, origConnect }()
+
+	newClient := &mongo.Client{}
+
+	e := &Exporter{
+		logger:        slog.Default(),
+		opts:          &Opts{GlobalConnPool: true},
+		client:        &mongo.Client{},
+		clientHealthy: true,
+	}
+
+	var pingCalls int32
+
+	pingFn = func(_ context.Context, _ *mongo.Client) error {
+		pingCalls++
+
+		return errors.New("connection lost")
+	}
+
+	var reconnectWG sync.WaitGroup
+
+	reconnectWG.Add(1)
+
+	connectFn = func(_ context.Context, _ mongoConnectOpts) (*mongo.Client, error) {
+		defer reconnectWG.Done()
+
+		return newClient, nil
+	}
+
+	client, err := e.getGlobalClient(context.Background())
+	assert.NoError(t, err)
+	assert.NotNil(t, client, "a stale client is returned immediately, not an error")
+	assert.False(t, e.isHealthy(), "ping failure must mark the client unhealthy")
+
+	waitOrFail(t, &reconnectWG, "reconnect")
+
+	// reconnectGlobalClient runs in a goroutine; give its post-connect
+	// bookkeeping (clientMu-guarded) a moment to complete.
+	assert.Eventually(t, func() bool { return e.isHealthy() }, time.Second, time.Millisecond,
+		"reconnect success must flip clientHealthy back to true")
+
+	e.clientMu.Lock()
+	got := e.client
+	stillReconnecting := e.reconnecting
+	e.clientMu.Unlock()
+
+	assert.Same(t, newClient, got)
+	assert.False(t, stillReconnecting)
+	assert.EqualValues(t, 1, pingCalls)
+}
+
+func waitOrFail(t *testing.T, wg *sync.WaitGroup, what string) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}